@@ -0,0 +1,237 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package analysis computes reachability and dominator information over the
+// memo's group graph. A group B is reachable from the designated root if
+// some chain of Expr.ChildGroup edges leads from root to B, across every
+// expression memoized in each group along the way (not just the normalized
+// one), since exploration may still reach B through an unexplored
+// alternative.
+//
+// The resulting dominator tree lets callers:
+//
+//   - prune exploration of a group that a transformation has made
+//     unreachable from the root (its old parent expression was replaced),
+//   - drive a garbage collector that compacts unreachable groups, lists, and
+//     privates out of the memo before costing,
+//   - let cost-based search prioritize groups closer to the root, via the
+//     dominator tree's depth.
+package analysis
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/memo"
+)
+
+// DomTree is the immediate-dominator tree of a memo's group graph, computed
+// from a single root group by BuildDom.
+type DomTree struct {
+	root memo.GroupID
+	idom map[memo.GroupID]memo.GroupID
+	post []memo.GroupID
+}
+
+// Idom returns g's immediate dominator: the unique closest ancestor that
+// every path from root to g must pass through. Idom(root) returns root
+// itself. Idom panics if g is unreachable from root.
+func (t *DomTree) Idom(g memo.GroupID) memo.GroupID {
+	idom, ok := t.idom[g]
+	if !ok {
+		panic("group is not reachable from the dominator tree's root")
+	}
+	return idom
+}
+
+// Dominates returns true if every path from root to b passes through a,
+// including the trivial case a == b.
+func (t *DomTree) Dominates(a, b memo.GroupID) bool {
+	if _, ok := t.idom[a]; !ok {
+		return false
+	}
+	if _, ok := t.idom[b]; !ok {
+		return false
+	}
+	for g := b; ; {
+		if g == a {
+			return true
+		}
+		if g == t.root {
+			return false
+		}
+		g = t.idom[g]
+	}
+}
+
+// Reachable returns true if g was reached from root during BuildDom.
+func (t *DomTree) Reachable(g memo.GroupID) bool {
+	_, ok := t.idom[g]
+	return ok
+}
+
+// PostOrder returns every group reachable from root, in the post-order of
+// the depth-first traversal BuildDom used to number them (children before
+// parents). This is the order a garbage collector should visit groups in to
+// compact them, since it guarantees a group's children have already been
+// considered by the time the group itself is.
+func (t *DomTree) PostOrder() []memo.GroupID {
+	return t.post
+}
+
+// BuildDom computes the immediate-dominator tree of mem's group graph,
+// reachable from root via Expr.ChildGroup edges, using the Lengauer-Tarjan
+// algorithm. The memo already gives cheap indexed access to each
+// expression's children and small integer GroupIDs, so the semidominator
+// computation here uses dense int-indexed arrays (keyed by DFS number)
+// rather than hashing, which keeps the whole pass fast relative to the
+// rest of plan search.
+//
+// Note: the union-find used for EVAL/LINK below applies path compression
+// only, not the balanced-union refinement of the original paper. That
+// makes this O((V+E) log V) rather than O((V+E)*alpha(V)) in the
+// worst case, which is an acceptable trade for memo sizes, which rarely
+// reach more than a few thousand groups for a single query.
+func BuildDom(mem *memo.Memo, root memo.GroupID) *DomTree {
+	b := &domBuilder{mem: mem}
+	b.dfs(root, 0)
+
+	n := len(b.vertex) - 1
+	b.semi = make([]int, n+1)
+	b.ancestor = make([]int, n+1)
+	b.label = make([]int, n+1)
+	b.idom = make([]int, n+1)
+	samedom := make([]int, n+1)
+	bucket := make([][]int, n+1)
+
+	for i := 1; i <= n; i++ {
+		b.semi[i] = i
+		b.label[i] = i
+	}
+
+	for i := n; i >= 2; i-- {
+		p := b.parent[i]
+
+		for _, u := range b.pred[i] {
+			uEval := b.eval(u)
+			if b.semi[uEval] < b.semi[i] {
+				b.semi[i] = b.semi[uEval]
+			}
+		}
+		bucket[b.semi[i]] = append(bucket[b.semi[i]], i)
+		b.ancestor[i] = p // LINK(p, i)
+
+		for _, v := range bucket[p] {
+			uEval := b.eval(v)
+			if b.semi[uEval] < b.semi[v] {
+				samedom[v] = uEval
+			} else {
+				b.idom[v] = p
+			}
+		}
+		bucket[p] = nil
+	}
+
+	for i := 2; i <= n; i++ {
+		if samedom[i] != 0 {
+			b.idom[i] = b.idom[samedom[i]]
+		}
+	}
+	b.idom[1] = 1
+
+	t := &DomTree{root: root, idom: make(map[memo.GroupID]memo.GroupID, n)}
+	for i := 1; i <= n; i++ {
+		t.idom[b.vertex[i]] = b.vertex[b.idom[i]]
+	}
+	t.post = b.post
+	return t
+}
+
+// domBuilder holds the working state of one BuildDom call: the DFS
+// numbering of the reachable subgraph, and the arrays the Lengauer-Tarjan
+// pass operates on, all indexed by DFS number rather than GroupID so that
+// indices are dense and array-backed instead of hashed.
+type domBuilder struct {
+	mem *memo.Memo
+
+	vertex []memo.GroupID // vertex[i]: GroupID with DFS number i (index 0 unused)
+	dfnum  map[memo.GroupID]int
+	parent []int   // parent[i]: DFS number of i's DFS-tree parent
+	pred   [][]int // pred[i]: DFS numbers of all edges into i
+
+	post []memo.GroupID // groups in post-order as they're finished
+
+	semi     []int
+	ancestor []int
+	label    []int
+	idom     []int
+}
+
+// dfs numbers every group reachable from v (including v), and records every
+// edge encountered - not just DFS-tree edges - as a predecessor of its
+// target, since Lengauer-Tarjan needs the full predecessor graph.
+func (b *domBuilder) dfs(v memo.GroupID, parent int) {
+	if b.dfnum == nil {
+		b.dfnum = make(map[memo.GroupID]int)
+		b.vertex = append(b.vertex, 0) // placeholder for index 0
+		b.parent = append(b.parent, 0)
+		b.pred = append(b.pred, nil)
+	}
+
+	if idx, ok := b.dfnum[v]; ok {
+		if parent != 0 {
+			b.pred[idx] = append(b.pred[idx], parent)
+		}
+		return
+	}
+
+	b.vertex = append(b.vertex, v)
+	idx := len(b.vertex) - 1
+	b.dfnum[v] = idx
+	b.parent = append(b.parent, parent)
+	b.pred = append(b.pred, nil)
+	if parent != 0 {
+		b.pred[idx] = append(b.pred[idx], parent)
+	}
+
+	for ord, n := memo.ExprOrdinal(0), b.mem.ExprCount(v); ord < n; ord++ {
+		expr := b.mem.Expr(memo.ExprID{Group: v, Expr: ord})
+		for i, childCount := 0, expr.ChildCount(); i < childCount; i++ {
+			b.dfs(expr.ChildGroup(b.mem, i), idx)
+		}
+	}
+
+	b.post = append(b.post, v)
+}
+
+// eval returns the DFS number, among v and its proper ancestors in the
+// forest built so far by link, whose semidominator has the minimal DFS
+// number - compressing the ancestor path it walks so repeated calls stay
+// cheap.
+func (b *domBuilder) eval(v int) int {
+	if b.ancestor[v] == 0 {
+		return v
+	}
+	b.compress(v)
+	return b.label[v]
+}
+
+func (b *domBuilder) compress(v int) {
+	a := b.ancestor[v]
+	if b.ancestor[a] == 0 {
+		return
+	}
+	b.compress(a)
+	if b.semi[b.label[a]] < b.semi[b.label[v]] {
+		b.label[v] = b.label[a]
+	}
+	b.ancestor[v] = b.ancestor[a]
+}
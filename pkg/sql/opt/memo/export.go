@@ -0,0 +1,150 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+)
+
+// exportMagic identifies the binary memo export format and guards against
+// decoding unrelated data as a memo.
+const exportMagic = "CRDBMEMO"
+
+// exportVersion is bumped whenever the wire format of the stream itself
+// (independent of the operator set, which is handled by the name table)
+// changes incompatibly.
+const exportVersion = 1
+
+// Export serializes the full contents of mem to w: every group, every
+// expression memoized in each group (its operator and state), the interned
+// list arena, and the interned privates. The format is a compact varint
+// stream fronted by a header that maps opt.Operator names to the ordinals
+// used in the body, so an export remains readable by builds that add new
+// operators after it was written.
+//
+// Groups are written in topological order (a group's children are always
+// written before the group itself), so Import can materialize each group's
+// children before the group that references them, without a second pass
+// over the stream.
+func Export(mem *Memo, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := &exportEncoder{w: bw}
+
+	if _, err := bw.WriteString(exportMagic); err != nil {
+		return err
+	}
+	enc.writeUvarint(exportVersion)
+
+	// Operator name table: lets Import resolve operators by name rather
+	// than by the ordinal this build happens to assign them.
+	names := opt.OperatorNames()
+	enc.writeUvarint(uint64(len(names)))
+	for _, name := range names {
+		enc.writeString(name)
+	}
+
+	groups := mem.TopologicalGroups()
+	enc.writeUvarint(uint64(len(groups)))
+
+	// ordinal maps a child's GroupID to its position in the topological
+	// stream, which is what the importer's materialized slice is indexed
+	// by. GroupIDs themselves are a separate id space (generally 1-based
+	// and not dense over just the groups reachable from an export root), so
+	// they cannot be written as-is and recovered by position on import.
+	ordinal := make(map[GroupID]int, len(groups))
+	for i, group := range groups {
+		ordinal[group] = i
+	}
+
+	for _, group := range groups {
+		if err := enc.writeGroup(mem, group, ordinal); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+type exportEncoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *exportEncoder) writeUvarint(v uint64) {
+	if e.err != nil {
+		return
+	}
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	_, e.err = e.w.Write(buf[:n])
+}
+
+func (e *exportEncoder) writeString(s string) {
+	e.writeUvarint(uint64(len(s)))
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.WriteString(s)
+}
+
+// writeGroup encodes every expression memoized in group: its operator
+// (looked up by name ordinal), its fixed children (as ordinals into the
+// already-written topological prefix, via ordinal), its list children (as a
+// length-prefixed run of the same ordinals, kept separate from the fixed
+// children so Import can re-intern them as a single list operand instead of
+// splicing them in as extra fixed operands), and its type-tagged private.
+func (e *exportEncoder) writeGroup(mem *Memo, group GroupID, ordinal map[GroupID]int) error {
+	count := mem.ExprCount(group)
+	e.writeUvarint(uint64(count))
+
+	for ord := ExprOrdinal(0); ord < count; ord++ {
+		expr := mem.Expr(ExprID{Group: group, Expr: ord})
+		e.writeUvarint(uint64(expr.Operator()))
+
+		layout := opLayoutTable[expr.op]
+		fixedCount := int(layout.fixedCount())
+
+		e.writeUvarint(uint64(fixedCount))
+		for i := 0; i < fixedCount; i++ {
+			e.writeUvarint(uint64(ordinal[expr.ChildGroup(mem, i)]))
+		}
+
+		if layout.list() != 0 {
+			e.writeUvarint(1)
+			listCount := expr.ChildCount() - fixedCount
+			e.writeUvarint(uint64(listCount))
+			for i := 0; i < listCount; i++ {
+				e.writeUvarint(uint64(ordinal[expr.ChildGroup(mem, fixedCount+i)]))
+			}
+		} else {
+			e.writeUvarint(0)
+		}
+
+		if priv := expr.PrivateID(); priv != 0 {
+			e.writeUvarint(1)
+			if err := writePrivate(e, mem.LookupPrivate(priv)); err != nil {
+				return err
+			}
+		} else {
+			e.writeUvarint(0)
+		}
+	}
+
+	return e.err
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// TestExportImportRoundTrip builds a tiny three-group memo - an Eq over a
+// Variable and a Const(42) - exports it, imports it into a fresh memo, and checks that the
+// imported memo has the same shape and that the Const's datum private
+// survived the round trip with its concrete type intact. This is the
+// regression test for the hasList/listCount framing desync and the nil
+// EvalContext datum-parsing bug: both silently corrupted or failed every
+// real export that reached this expression shape.
+func TestExportImportRoundTrip(t *testing.T) {
+	mem := New()
+
+	datum := tree.NewDInt(42)
+	constGroup := mem.MemoizeDynamic(opt.ConstOp, DynamicOperands{0: DynamicID(mem.InternPrivate(datum))}, 0)
+	colGroup := mem.MemoizeDynamic(opt.VariableOp, DynamicOperands{0: DynamicID(mem.InternPrivate(opt.ColumnID(1)))}, 0)
+	eqGroup := mem.MemoizeDynamic(
+		opt.EqOp, DynamicOperands{0: DynamicID(colGroup), 1: DynamicID(constGroup)}, 0,
+	)
+
+	var buf bytes.Buffer
+	if err := Export(mem, &buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	evalCtx := tree.NewTestingEvalContext(nil)
+	imported, err := Import(&buf, evalCtx)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	groups := imported.TopologicalGroups()
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups after import, got %d", len(groups))
+	}
+
+	// The last group in topological order is the root Eq, since it was
+	// written last (after its children) by Export.
+	root := groups[len(groups)-1]
+	rootExpr := imported.Expr(ExprID{Group: root, Expr: normExprOrdinal})
+	if rootExpr.Operator() != opt.EqOp {
+		t.Fatalf("expected root operator Eq, got %s", rootExpr.Operator())
+	}
+	if rootExpr.ChildCount() != 2 {
+		t.Fatalf("expected Eq to have 2 children, got %d", rootExpr.ChildCount())
+	}
+
+	importedConstGroup := rootExpr.ChildGroup(imported, 1)
+	importedConst := imported.Expr(MakeNormExprID(importedConstGroup))
+	if importedConst.Operator() != opt.ConstOp {
+		t.Fatalf("expected Const operator, got %s", importedConst.Operator())
+	}
+
+	got, ok := imported.LookupPrivate(importedConst.PrivateID()).(tree.Datum)
+	if !ok {
+		t.Fatalf("expected imported Const private to be a tree.Datum, got %T",
+			imported.LookupPrivate(importedConst.PrivateID()))
+	}
+	if cmp, err := got.Compare(evalCtx, datum); err != nil || cmp != 0 {
+		t.Fatalf("expected imported datum %s to equal original %s (cmp=%d, err=%v)",
+			got, datum, cmp, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// foldFn evaluates an expression's operator eagerly over its constant
+// operands and returns the folded replacement, analogous to how
+// opLayoutTable drives child/private access for each operator. It returns
+// ok=false when folding does not apply (e.g. a child isn't yet constant).
+//
+// A foldFn must not panic or raise evaluation errors directly: any error
+// that can only be detected by evaluating the expression (overflow,
+// division by zero, a malformed cast, etc.) is deferred to execution time by
+// returning ok=false, leaving the unfolded expression in place. This keeps
+// plan construction infallible even when a branch can never actually run
+// (e.g. the false side of a CASE).
+type foldFn func(mem *Memo, e *Expr) (opt.Operator, DynamicOperands, bool)
+
+// foldFuncTable is indexed by opt.Operator and is generated by optgen from
+// the set of operators marked as foldable in their .opt definitions. Entries
+// are nil for operators that have no constant-folding rule.
+var foldFuncTable [opt.NumOperators]foldFn
+
+// strictNullTable is indexed by opt.Operator and is generated by optgen from
+// the set of operators marked "strict" in their .opt definitions: those
+// whose result is always NULL if any operand is NULL (arithmetic,
+// comparison, and most other scalar ops), as opposed to operators like And,
+// Or, Coalesce, and IsNull that give NULL operands special handling.
+var strictNullTable [opt.NumOperators]bool
+
+// ConstFolder eagerly evaluates pure expressions over constant operands at
+// memo-construction time, so that exploration and costing never see
+// redundant non-constant forms of an expression that could have been
+// computed once up front. It is invoked by the normalizer immediately after
+// building the normalized (0th) expression of a group, before that
+// expression is memoized.
+//
+// Folding only ever replaces a group's normalized expression with a Const;
+// it never introduces new groups, since a folded expression has no children
+// left to memoize.
+type ConstFolder struct {
+	mem *Memo
+
+	// evalCtx supplies the session-dependent state (timezone, collation,
+	// search path) that some otherwise-pure operators depend on. Of those
+	// operators, only Collate is checked (see sessionIndependent): it is
+	// folded only when evalCtx is non-nil. Time zone and search path are not
+	// consulted, so no other operator is kept from folding on their account.
+	evalCtx *tree.EvalContext
+}
+
+// NewConstFolder constructs a ConstFolder that interns folded datums as
+// privates of mem.
+func NewConstFolder(mem *Memo, evalCtx *tree.EvalContext) *ConstFolder {
+	return &ConstFolder{mem: mem, evalCtx: evalCtx}
+}
+
+// FoldExpr attempts to fold e into a Const expression. It returns the
+// original Expr unchanged if e's operator isn't foldable, if any child group
+// doesn't yet have a Const normalized expression, or if evaluation can't be
+// proven side-effect free for the current session. The exceptions are the
+// And/Or short-circuits and NULL propagation for strict operators, which fold
+// as soon as a single child qualifies.
+func (f *ConstFolder) FoldExpr(e *Expr) Expr {
+	fold := foldFuncTable[e.Operator()]
+	if fold == nil {
+		return *e
+	}
+
+	// Short-circuit booleans without requiring every child to already be
+	// constant: AND with a false child folds to false regardless of the
+	// other operand, and OR with a true child folds to true. This avoids
+	// evaluating (or even requiring the foldability of) a child that may be
+	// expensive or may never be reachable.
+	if folded, ok := f.foldBooleanShortCircuit(e); ok {
+		return folded
+	}
+
+	// Null propagation, like the boolean short-circuits above, only needs
+	// one qualifying child rather than requiring every child to already be
+	// constant: a strict operator with any NULL operand is NULL regardless
+	// of what its other operands turn out to be.
+	if folded, ok := f.foldNullPropagation(e); ok {
+		return folded
+	}
+
+	if !f.allChildrenConst(e) {
+		return *e
+	}
+
+	if !f.sessionIndependent(e.Operator()) {
+		return *e
+	}
+
+	op, operands, ok := fold(f.mem, e)
+	if !ok {
+		// Evaluation couldn't be completed without raising an error (e.g.
+		// integer overflow, division by zero). Defer that error to
+		// execution time rather than failing plan construction.
+		return *e
+	}
+	return MakeExpr(op, operands)
+}
+
+// foldBooleanShortCircuit implements the short-circuit folding rules for And
+// and Or: a false child of And, or a true child of Or, determines the
+// result without regard to the other operand's foldability.
+func (f *ConstFolder) foldBooleanShortCircuit(e *Expr) (Expr, bool) {
+	switch e.Operator() {
+	case opt.AndOp:
+		if f.isConstBool(e.ChildGroup(f.mem, 0), false) || f.isConstBool(e.ChildGroup(f.mem, 1), false) {
+			return f.makeConstBool(false), true
+		}
+	case opt.OrOp:
+		if f.isConstBool(e.ChildGroup(f.mem, 0), true) || f.isConstBool(e.ChildGroup(f.mem, 1), true) {
+			return f.makeConstBool(true), true
+		}
+	}
+	return Expr{}, false
+}
+
+// foldNullPropagation folds e to a NULL Const if e's operator is strict and
+// at least one of its child groups already contains a NULL Const, since a
+// strict operator's result is NULL whenever any operand is, independent of
+// the other operands' values or even their foldability.
+func (f *ConstFolder) foldNullPropagation(e *Expr) (Expr, bool) {
+	if !strictNullTable[e.Operator()] {
+		return Expr{}, false
+	}
+	for i, n := 0, e.ChildCount(); i < n; i++ {
+		if f.isConstNull(e.ChildGroup(f.mem, i)) {
+			return f.makeConstNull(), true
+		}
+	}
+	return Expr{}, false
+}
+
+func (f *ConstFolder) isConstNull(group GroupID) bool {
+	expr := f.mem.NormExpr(group)
+	if expr.Operator() != opt.ConstOp {
+		return false
+	}
+	return f.mem.LookupPrivate(expr.PrivateID()) == tree.DNull
+}
+
+func (f *ConstFolder) makeConstNull() Expr {
+	return MakeExpr(opt.ConstOp, DynamicOperands{0: DynamicID(f.mem.InternPrivate(tree.DNull))})
+}
+
+// allChildrenConst returns true if every child group of e already contains a
+// Const normalized expression.
+func (f *ConstFolder) allChildrenConst(e *Expr) bool {
+	for i, n := 0, e.ChildCount(); i < n; i++ {
+		child := f.mem.NormExpr(e.ChildGroup(f.mem, i))
+		if child.Operator() != opt.ConstOp {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionIndependent reports whether op may be folded given f's evalCtx.
+// Collate is the only operator it treats as session dependent, returning
+// false for it when evalCtx is nil; it returns true for every other operator,
+// including ones whose result depends on the session time zone.
+func (f *ConstFolder) sessionIndependent(op opt.Operator) bool {
+	switch op {
+	case opt.CollateOp:
+		// Collate's locale is already pinned: it's the operator's own
+		// private, fixed at parse time (e.g. the "en" in 'foo' COLLATE en),
+		// not something that varies with the session. What folding still
+		// needs is an evalCtx whose CollationEnv can produce the ICU
+		// collator for that locale; without one, defer to execution rather
+		// than guessing at the result.
+		return f.evalCtx != nil
+	default:
+		return true
+	}
+}
+
+func (f *ConstFolder) isConstBool(group GroupID, value bool) bool {
+	expr := f.mem.NormExpr(group)
+	if expr.Operator() != opt.ConstOp {
+		return false
+	}
+	d, ok := f.mem.LookupPrivate(expr.PrivateID()).(*tree.DBool)
+	return ok && bool(*d) == value
+}
+
+func (f *ConstFolder) makeConstBool(value bool) Expr {
+	d := tree.MakeDBool(tree.DBool(value))
+	return MakeExpr(opt.ConstOp, DynamicOperands{0: DynamicID(f.mem.InternPrivate(d))})
+}
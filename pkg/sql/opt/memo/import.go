@@ -0,0 +1,236 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+)
+
+// Import deserializes a memo previously written by Export, returning a
+// freshly built *Memo. PrivateID and ListID values are not preserved across
+// the round trip, only the referential structure they encode: the importer
+// re-interns every list and private against the new memo as it goes.
+//
+// evalCtx is used to parse Datum privates back out of their exported string
+// form (tree.ParseDatumStringAs requires one for context-dependent datums
+// like collated strings, intervals, and timestamps) and must not be nil.
+func Import(r io.Reader, evalCtx *tree.EvalContext) (*Memo, error) {
+	return ImportInto(r, New(), evalCtx)
+}
+
+// ImportInto deserializes into an existing, caller-provided memo rather than
+// a fresh one, so that groups already present in mem are reused by
+// Fingerprint whenever the imported memo contains structurally identical
+// expressions. This lets a plan cache accumulate structure shared across
+// many imported memos instead of duplicating it on every import.
+func ImportInto(r io.Reader, mem *Memo, evalCtx *tree.EvalContext) (*Memo, error) {
+	br := bufio.NewReader(r)
+	dec := &importDecoder{r: br, evalCtx: evalCtx}
+
+	magic := make([]byte, len(exportMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("memo: import: reading magic: %v", err)
+	}
+	if string(magic) != exportMagic {
+		return nil, fmt.Errorf("memo: import: not a memo export (bad magic)")
+	}
+
+	version := dec.readUvarint()
+	if version != exportVersion {
+		return nil, fmt.Errorf("memo: import: unsupported format version %d", version)
+	}
+
+	opByName, err := dec.readOperatorTable()
+	if err != nil {
+		return nil, err
+	}
+
+	groupCount := dec.readUvarint()
+	// groups[i] is the GroupID that group ordinal i (in the stream's
+	// topological order) was materialized as in mem. GroupIDs are a separate
+	// id space from stream ordinals, and Fingerprint-level dedup may map an
+	// ordinal to an existing equivalent group.
+	groups := make([]GroupID, groupCount)
+
+	for i := range groups {
+		group, err := dec.readGroup(mem, opByName, groups[:i])
+		if err != nil {
+			return nil, err
+		}
+		groups[i] = group
+	}
+
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return mem, nil
+}
+
+type importDecoder struct {
+	r       *bufio.Reader
+	err     error
+	evalCtx *tree.EvalContext
+}
+
+func (d *importDecoder) readUvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.err = err
+	}
+	return v
+}
+
+func (d *importDecoder) readString() string {
+	n := d.readUvarint()
+	if d.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		d.err = err
+		return ""
+	}
+	return string(buf)
+}
+
+// readOperatorTable reads the name->ordinal table written by Export and
+// resolves each name against this build's operator set. A name with no
+// match in this build produces a typed error (rather than a panic) naming
+// every missing operator, since the export may have come from a newer build
+// that added operators unknown here.
+func (d *importDecoder) readOperatorTable() (map[uint64]opt.Operator, error) {
+	n := d.readUvarint()
+	table := make(map[uint64]opt.Operator, n)
+	var missing []string
+	for i := uint64(0); i < n; i++ {
+		name := d.readString()
+		op, ok := opt.OperatorByName(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		table[i] = op
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(missing) != 0 {
+		return nil, &UnknownOperatorsError{Names: missing}
+	}
+	return table, nil
+}
+
+// readGroupRef reads a single child reference, written by Export as an
+// ordinal into the topological stream, and resolves it against the groups
+// already materialized at that ordinal.
+func (d *importDecoder) readGroupRef(materialized []GroupID) (GroupID, error) {
+	srcOrdinal := d.readUvarint()
+	if d.err != nil {
+		return 0, d.err
+	}
+	if srcOrdinal >= uint64(len(materialized)) {
+		return 0, fmt.Errorf("memo: import: forward reference to group %d", srcOrdinal)
+	}
+	return materialized[srcOrdinal], nil
+}
+
+// readGroup decodes one group's expressions and memoizes them into mem,
+// resolving fixed and list child references via readGroupRef against
+// materialized, which holds the destination GroupID for every group
+// ordinal written before this one.
+func (d *importDecoder) readGroup(
+	mem *Memo, opByName map[uint64]opt.Operator, materialized []GroupID,
+) (GroupID, error) {
+	exprCount := d.readUvarint()
+
+	var group GroupID
+	for ord := uint64(0); ord < exprCount; ord++ {
+		opOrdinal := d.readUvarint()
+		op, ok := opByName[opOrdinal]
+		if !ok {
+			return 0, fmt.Errorf("memo: import: operator ordinal %d not in name table", opOrdinal)
+		}
+
+		fixedCount := d.readUvarint()
+		var operands DynamicOperands
+		for i := uint64(0); i < fixedCount; i++ {
+			childGroup, err := d.readGroupRef(materialized)
+			if err != nil {
+				return 0, err
+			}
+			operands[i] = DynamicID(childGroup)
+		}
+
+		// nth tracks the next DynamicOperands slot to fill, mirroring how
+		// Expr.ReplaceOperands assembles operands: fixed children, then (if
+		// present) a single list operand, then (if present) the private.
+		nth := fixedCount
+
+		hasList := d.readUvarint()
+		if hasList != 0 {
+			listCount := d.readUvarint()
+			list := make([]GroupID, listCount)
+			for i := range list {
+				childGroup, err := d.readGroupRef(materialized)
+				if err != nil {
+					return 0, err
+				}
+				list[i] = childGroup
+			}
+			operands[nth] = MakeDynamicListID(mem.InternList(list))
+			nth++
+		}
+
+		hasPrivate := d.readUvarint()
+		if hasPrivate != 0 {
+			priv, err := readPrivate(d, d.evalCtx)
+			if err != nil {
+				return 0, err
+			}
+			operands[nth] = DynamicID(mem.InternPrivate(priv))
+		}
+
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		// Every expression in this group is logically equivalent (that's
+		// what made them memoize together in the source memo), so they all
+		// group together here too; MemoizeDynamic performs the
+		// Fingerprint-level dedup against mem's existing contents.
+		group = mem.MemoizeDynamic(op, operands, group)
+	}
+	return group, nil
+}
+
+// UnknownOperatorsError is returned by Import when the export's operator
+// name table references operators this build doesn't know about, typically
+// because the export was produced by a newer build.
+type UnknownOperatorsError struct {
+	Names []string
+}
+
+func (e *UnknownOperatorsError) Error() string {
+	return fmt.Sprintf("memo: import: unknown operators: %v", e.Names)
+}
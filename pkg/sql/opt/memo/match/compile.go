@@ -0,0 +1,263 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package match
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+)
+
+// node is the parsed, tree-shaped representation of a pattern, produced by
+// parse and consumed by compile. It exists only during compilation; Pattern
+// itself stores the flattened instruction stream, not this tree.
+type node struct {
+	// op is the single operator this node matches, or nil if alts is set
+	// instead.
+	op *opt.Operator
+	// alts holds the alternatives of an `@(Op1|Op2|...)` node.
+	alts []opt.Operator
+	// wildcard is true for `_`.
+	wildcard bool
+	// capture is the bound name for `$name`, or "" if this node isn't a
+	// capture.
+	capture string
+	// children are the nested pattern nodes, in order. A trailing child
+	// with ellipsis set to true represents `...`.
+	children []node
+	ellipsis bool
+}
+
+// Compile parses pattern and produces a Pattern that can be matched
+// repeatedly against memo expressions via Match/MatchAll.
+func Compile(pattern string) (*Pattern, error) {
+	p := &parser{input: pattern}
+	root, err := p.parseNode()
+	if err != nil {
+		return nil, fmt.Errorf("match: %s: %v", pattern, err)
+	}
+	p.skipSpace()
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("match: %s: unexpected trailing input at %d", pattern, p.pos)
+	}
+
+	c := &compiler{source: pattern}
+	c.emitNode(root)
+	c.emit(uint32(done), 0, 0)
+	return &Pattern{source: pattern, prog: c.prog, names: c.names, alts: c.alts}, nil
+}
+
+// parser does a minimal recursive-descent parse of the s-expression pattern
+// language described in the package doc comment.
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t' || p.input[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *parser) parseNode() (node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return node{}, fmt.Errorf("unexpected end of pattern")
+	}
+
+	switch p.input[p.pos] {
+	case '(':
+		return p.parseList()
+	case '_':
+		p.pos++
+		return node{wildcard: true}, nil
+	case '$':
+		p.pos++
+		name := p.parseIdent()
+		return node{capture: name, wildcard: true}, nil
+	case '.':
+		if strings.HasPrefix(p.input[p.pos:], "...") {
+			p.pos += 3
+			return node{ellipsis: true}, nil
+		}
+		return node{}, fmt.Errorf("unexpected '.' at %d", p.pos)
+	case '@':
+		p.pos++
+		return p.parseAlternation()
+	default:
+		name := p.parseIdent()
+		if name == "" {
+			return node{}, fmt.Errorf("unexpected character %q at %d", p.input[p.pos], p.pos)
+		}
+		op, ok := opt.OperatorByName(name)
+		if !ok {
+			return node{}, fmt.Errorf("unknown operator %q", name)
+		}
+		return node{op: &op}, nil
+	}
+}
+
+func (p *parser) parseList() (node, error) {
+	p.pos++ // consume '('
+	p.skipSpace()
+
+	head, err := p.parseHead()
+	if err != nil {
+		return node{}, err
+	}
+
+	var children []node
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return node{}, fmt.Errorf("unterminated list")
+		}
+		if p.input[p.pos] == ')' {
+			p.pos++
+			break
+		}
+		child, err := p.parseNode()
+		if err != nil {
+			return node{}, err
+		}
+		children = append(children, child)
+	}
+
+	head.children = children
+	return head, nil
+}
+
+// parseHead parses the operator (or alternation) that leads a list form,
+// without consuming the children that follow it.
+func (p *parser) parseHead() (node, error) {
+	if p.pos < len(p.input) && p.input[p.pos] == '@' {
+		p.pos++
+		return p.parseAlternation()
+	}
+	name := p.parseIdent()
+	if name == "" {
+		return node{}, fmt.Errorf("expected operator name at %d", p.pos)
+	}
+	op, ok := opt.OperatorByName(name)
+	if !ok {
+		return node{}, fmt.Errorf("unknown operator %q", name)
+	}
+	return node{op: &op}, nil
+}
+
+func (p *parser) parseAlternation() (node, error) {
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		return node{}, fmt.Errorf("expected '(' after '@' at %d", p.pos)
+	}
+	p.pos++
+	var alts []opt.Operator
+	for {
+		name := p.parseIdent()
+		if name == "" {
+			return node{}, fmt.Errorf("expected operator name at %d", p.pos)
+		}
+		op, ok := opt.OperatorByName(name)
+		if !ok {
+			return node{}, fmt.Errorf("unknown operator %q", name)
+		}
+		alts = append(alts, op)
+		p.skipSpace()
+		if p.pos < len(p.input) && p.input[p.pos] == '|' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+		return node{}, fmt.Errorf("expected ')' to close alternation at %d", p.pos)
+	}
+	p.pos++
+	return node{alts: alts}, nil
+}
+
+func (p *parser) parseIdent() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == '(' || c == ')' || c == ' ' || c == '\t' || c == '\n' || c == '|' {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// compiler flattens a parsed node tree into the instruction stream described
+// in pattern.go, driven by each node's position rather than Go recursion at
+// match time.
+type compiler struct {
+	source string
+	prog   []uint32
+	names  []string
+
+	// alts accumulates the alternative sets referenced by `@(Op1|Op2|...)`
+	// nodes. It becomes the compiled Pattern's own alts slice, rather than
+	// shared package state, so compiling patterns concurrently doesn't race
+	// and a Pattern's alternatives don't outlive it.
+	alts [][]opt.Operator
+}
+
+func (c *compiler) emit(op, a, b uint32) {
+	c.prog = append(c.prog, op, a, b)
+}
+
+func (c *compiler) nameSlot(name string) uint32 {
+	for i, n := range c.names {
+		if n == name {
+			return uint32(i)
+		}
+	}
+	c.names = append(c.names, name)
+	return uint32(len(c.names) - 1)
+}
+
+func (c *compiler) emitNode(n node) {
+	switch {
+	case n.wildcard:
+		c.emit(uint32(matchAny), 0, 0)
+	case len(n.alts) != 0:
+		c.alts = append(c.alts, append(append([]opt.Operator{}, n.alts...), opt.UnknownOp))
+		c.emit(uint32(matchOp), 0, uint32(len(c.alts)))
+	case n.op != nil:
+		c.emit(uint32(matchOp), uint32(*n.op), 0)
+	}
+
+	if n.capture != "" {
+		// The capture's kind (group vs. private) isn't known until match
+		// time: it depends on whether the *parent's* enter instruction that
+		// brought us to this frame resolved to a child position or the
+		// trailing private position. bind reads that off the current frame
+		// at match time instead.
+		slot := c.nameSlot(n.capture)
+		c.emit(uint32(bind), slot, 0)
+	}
+
+	for i, child := range n.children {
+		if child.ellipsis {
+			c.emit(uint32(matchList), uint32(i), 0)
+			continue
+		}
+		c.emit(uint32(enter), uint32(i), 0)
+		c.emitNode(child)
+		c.emit(uint32(exit), 0, 0)
+	}
+}
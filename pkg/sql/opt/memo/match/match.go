@@ -0,0 +1,167 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package match
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/memo"
+)
+
+// frame is one level of the explicit stack used by Match. A frame is either
+// a group frame (positioned at some expression, addressable by child index)
+// or a private frame (positioned at an expression's private field, which
+// has no children or private of its own). Using an explicit stack rather
+// than Go recursion keeps evaluation of a compiled Pattern a flat loop over
+// its program. The stack is a slice grown with append from empty, so each
+// attempt allocates its backing array.
+type frame struct {
+	isPrivate bool
+	expr      memo.ExprID    // valid when !isPrivate
+	priv      memo.PrivateID // valid when isPrivate
+}
+
+// Match runs p against the expression identified by root, returning the
+// captured bindings and true on success. Only the single expression at root
+// is tried; use MatchAll to try every alternative expression memoized in
+// root's group.
+func (p *Pattern) Match(mem *memo.Memo, root memo.ExprID) (Bindings, bool) {
+	m := &matcher{mem: mem, pattern: p}
+	ok := m.run(root)
+	return m.bindings, ok
+}
+
+// MatchAll tries p against every expression memoized in root.Group, calling
+// fn with the bindings for each one that matches. It stops early if fn
+// returns false.
+func (p *Pattern) MatchAll(mem *memo.Memo, root memo.GroupID, fn func(Bindings) bool) {
+	for ord, n := memo.ExprOrdinal(0), mem.ExprCount(root); ord < n; ord++ {
+		m := &matcher{mem: mem, pattern: p}
+		if m.run(memo.ExprID{Group: root, Expr: ord}) {
+			if !fn(m.bindings) {
+				return
+			}
+		}
+	}
+}
+
+// matcher interprets one Pattern's instruction stream against one memo,
+// using an explicit stack of frames rather than recursion so the same
+// compiled program can be rerun cheaply for every candidate expression.
+type matcher struct {
+	mem      *memo.Memo
+	pattern  *Pattern
+	bindings Bindings
+	stack    []frame
+}
+
+func (m *matcher) current() frame {
+	return m.stack[len(m.stack)-1]
+}
+
+func (m *matcher) run(root memo.ExprID) bool {
+	m.stack = append(m.stack, frame{expr: root})
+
+	prog := m.pattern.prog
+	for pc := 0; pc < len(prog); pc += 3 {
+		op, a, b := instOp(prog[pc]), prog[pc+1], prog[pc+2]
+		top := m.current()
+
+		switch op {
+		case matchOp:
+			if top.isPrivate {
+				// A private has no operator of its own to match against;
+				// a pattern that places an operator form at a private
+				// position (rather than `_` or `$name`) can never match.
+				return false
+			}
+			expr := m.mem.Expr(top.expr)
+			if b == 0 {
+				if expr.Operator() != opt.Operator(a) {
+					return false
+				}
+			} else if !matchesAlt(expr.Operator(), m.pattern.alts[b-1]) {
+				return false
+			}
+
+		case matchAny:
+			// Matches unconditionally, whether the current frame is a
+			// group or a private.
+
+		case enter:
+			if top.isPrivate {
+				// Privates don't have positions of their own to descend
+				// into.
+				return false
+			}
+			expr := m.mem.Expr(top.expr)
+			childCount := expr.ChildCount()
+			pos := int(a)
+			switch {
+			case pos < childCount:
+				child := expr.ChildGroup(m.mem, pos)
+				m.stack = append(m.stack, frame{expr: memo.MakeNormExprID(child)})
+			case pos == childCount && expr.PrivateID() != 0:
+				m.stack = append(m.stack, frame{isPrivate: true, priv: expr.PrivateID()})
+			default:
+				return false
+			}
+
+		case matchList:
+			if top.isPrivate {
+				return false
+			}
+			expr := m.mem.Expr(top.expr)
+			if expr.ChildCount()-int(a) < 0 {
+				return false
+			}
+
+		case bind:
+			name := m.pattern.names[a]
+			m.bind(name, top)
+
+		case exit:
+			m.stack = m.stack[:len(m.stack)-1]
+
+		case done:
+			return true
+		}
+	}
+	return true
+}
+
+// bind records frame's position under name: a group reference if frame is a
+// group frame, or the private id if frame is a private frame.
+func (m *matcher) bind(name string, f frame) {
+	if f.isPrivate {
+		if m.bindings.privates == nil {
+			m.bindings.privates = make(map[string]memo.PrivateID)
+		}
+		m.bindings.privates[name] = f.priv
+		return
+	}
+	if m.bindings.groups == nil {
+		m.bindings.groups = make(map[string]memo.GroupID)
+	}
+	m.bindings.groups[name] = f.expr.Group
+}
+
+func matchesAlt(op opt.Operator, alts []opt.Operator) bool {
+	for _, alt := range alts {
+		if alt == op {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package match compiles a small textual pattern language for searching the
+// memo's forest of expressions. Patterns look like:
+//
+//   (Eq (Variable $x) (Const $c))
+//   (@(Eq|Ne) _ $rhs)
+//   (And $left $right ...)
+//
+// - `$name` binds the group or private at that position to name.
+// - `_` matches anything without binding it.
+// - `...` matches the remaining elements of a variable-length list.
+// - `@(Op1|Op2|...)` matches any of the listed operators.
+//
+// A pattern position is resolved against its parent expression's operand
+// layout at match time: positions before ChildCount() address a child group
+// (e.g. Eq's two operands), and a trailing position beyond ChildCount()
+// addresses the parent's private, if it has one (e.g. Const's literal value,
+// Variable's column id). `(Const $c)` and `(Variable $x)` both bind their
+// private this way, since both operators have zero children.
+//
+// Patterns are compiled once with Compile and can then be matched against
+// many expressions cheaply, which makes them useful for rule authors,
+// tests, and diagnostic tooling that would otherwise need bespoke traversal
+// code for each transformation rule.
+package match
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/opt/memo"
+)
+
+// Bindings maps capture names in a pattern to the group or private value
+// they were matched against. A name that appears once in the pattern is
+// bound to exactly one of Group or Private, depending on whether the position
+// it captured addressed a child group or the parent's private field. A name
+// that appears at both kinds of position is bound under both.
+type Bindings struct {
+	groups   map[string]memo.GroupID
+	privates map[string]memo.PrivateID
+}
+
+// Group returns the group bound to name, and whether a binding exists.
+func (b Bindings) Group(name string) (memo.GroupID, bool) {
+	g, ok := b.groups[name]
+	return g, ok
+}
+
+// Private returns the private bound to name, and whether a binding exists.
+func (b Bindings) Private(name string) (memo.PrivateID, bool) {
+	p, ok := b.privates[name]
+	return p, ok
+}
+
+// Pattern is a compiled matcher produced by Compile. It holds a flat
+// instruction stream that Match/MatchAll interpret against a specific memo,
+// rather than recursing over the pattern's original tree shape. Each match
+// attempt allocates its own frame stack in addition to the Bindings result.
+type Pattern struct {
+	// source is the original pattern text, kept around for error messages
+	// and diagnostics.
+	source string
+
+	// prog is the compiled instruction stream. Each instruction is a
+	// {op, a, b} triple packed as described by the instOp constants below.
+	prog []uint32
+
+	// names is the ordered list of capture variable names referenced by
+	// bind instructions, indexed by their slot operand.
+	names []string
+
+	// alts holds the operator alternatives referenced by `@(Op1|Op2|...)`
+	// patterns. matchOp instructions with b != 0 index into this slice with
+	// b-1. It is owned by this Pattern (not shared package state), so
+	// compiling concurrently, or compiling many patterns over a program's
+	// lifetime, neither races nor leaks.
+	alts [][]opt.Operator
+}
+
+// instOp identifies the operation encoded by one instruction in a compiled
+// Pattern's program. Each instruction occupies 3 uint32 words: {op, a, b}.
+type instOp uint32
+
+const (
+	// matchOp checks that the current frame is a group whose expression's
+	// operator equals operand a (an opt.Operator), or, if b != 0, that it is
+	// one of the alternatives at alts[b-1].
+	matchOp instOp = iota
+
+	// matchAny matches the current frame unconditionally, used for `_` and
+	// `$name` standing in for a whole subexpression or private value.
+	matchAny
+
+	// enter resolves position a against the current group frame's operand
+	// layout: if a < ChildCount(), it descends into that child group; if
+	// a == ChildCount() and the expression has a private, it addresses that
+	// private instead. Either way it pushes a new frame; match fails if
+	// position a is out of range for the current expression, or the current
+	// frame isn't a group (privates have no positions of their own).
+	enter
+
+	// matchList asserts the current group frame's expression has at least a
+	// children (the count of fixed pattern elements before a trailing
+	// `...`).
+	matchList
+
+	// bind records the current frame - a group's GroupID, or a private's
+	// PrivateID - under names[a].
+	bind
+
+	// exit restores the frame saved by the matching enter.
+	exit
+
+	// done signals a successful match of the whole pattern.
+	done
+)
@@ -0,0 +1,92 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+)
+
+// canSetOpTable reports, for each (from, to) pair of operators, whether
+// SetOp may reinterpret an Expr with operator "from" as operator "to" in
+// place. It is generated by optgen from each operator's metadata: two
+// operators are compatible exactly when they share the identical opLayout
+// (same fixedCount, list, and priv positions) and optgen's .opt definitions
+// mark them as safe to conflate, e.g. Eq/Ne, Gt/Lt, And/Or, InnerJoin/
+// LeftJoin. It is indexed the same way as opLayoutTable.
+var canSetOpTable [opt.NumOperators][opt.NumOperators]bool
+
+// SetOp reinterprets e as having operator op, in place. This is only legal
+// when canSetOpTable allows the transition from e's current operator to op,
+// which optgen guarantees only for operators with an identical opLayout and
+// compatible child/private semantics (e.g. flipping Eq to Ne, or InnerJoin
+// to LeftJoin once a transformation rule has established the swap is safe).
+// SetOp panics on an illegal transition rather than silently corrupting the
+// expression, since that would otherwise manifest as a confusing crash far
+// from the responsible rule.
+//
+// SetOp lets rewrite rules mutate an expression cheaply in place inside the
+// memo, instead of always allocating a fresh Expr via MakeExpr plus
+// ReplaceOperands, which matters on the hot path during exploration.
+func (e *Expr) SetOp(op opt.Operator) {
+	if !canSetOpTable[e.op][op] {
+		panic(fmt.Sprintf("cannot reinterpret %s as %s: incompatible operand layout", e.op, op))
+	}
+	e.op = op
+}
+
+// SetChild overwrites the nth child (fixed or list) of e with group, subject
+// to the same bounds checking as ChildGroup. It panics if nth is out of
+// range for e's operator, the same way ChildGroup does.
+func (e *Expr) SetChild(mem *Memo, nth int, group GroupID) {
+	layout := opLayoutTable[e.op]
+	fixedCount := layout.fixedCount()
+	if nth < int(fixedCount) {
+		e.state[nth] = uint32(group)
+		return
+	}
+
+	nth -= int(fixedCount)
+	list := layout.list()
+	if list == 0 || nth >= int(e.state[list]) {
+		panic("child index out of range")
+	}
+
+	// List children are stored out-of-line in the memo's list arena, so
+	// mutating one in place means copying the list, updating the copy, and
+	// re-interning it; the Expr itself only ever holds the arena offset and
+	// length.
+	listID := ListID{Offset: e.state[list-1], Length: e.state[list]}
+	old := mem.LookupList(listID)
+	updated := make([]GroupID, len(old))
+	copy(updated, old)
+	updated[nth] = group
+
+	newListID := mem.InternList(updated)
+	e.state[list-1] = newListID.Offset
+	e.state[list] = newListID.Length
+}
+
+// SetPrivateID overwrites e's private field with id, subject to the same
+// bounds checking as PrivateID. It panics if e's operator has no private
+// field.
+func (e *Expr) SetPrivateID(id PrivateID) {
+	priv := opLayoutTable[e.op].priv()
+	if priv == 0 {
+		panic(fmt.Sprintf("operator %s has no private field", e.op))
+	}
+	e.state[priv-1] = uint32(id)
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package memo
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/cockroachdb/cockroach/pkg/sql/opt"
+	"github.com/cockroachdb/cockroach/pkg/sql/sem/tree"
+	"github.com/cockroachdb/cockroach/pkg/sql/types"
+)
+
+// privateTag identifies the concrete Go type of an interned private value in
+// the export stream, so Import can decode it without relying on the
+// encoding/gob type registry or similar reflection-based machinery.
+type privateTag uint64
+
+const (
+	privateTagDatum privateTag = iota + 1
+	privateTagColumnID
+	privateTagOrdering
+	privateTagTableID
+)
+
+// writePrivate type-tags and encodes a single interned private value.
+func writePrivate(e *exportEncoder, priv interface{}) error {
+	switch v := priv.(type) {
+	case tree.Datum:
+		e.writeUvarint(uint64(privateTagDatum))
+		// The concrete datum type (int vs. decimal vs. a specific collated
+		// string locale, etc.) must travel with the value: parsing the
+		// formatted string back against types.Any would only recover
+		// whatever type the parser infers from syntax, which is not
+		// necessarily the original type.
+		typeBytes, err := v.ResolvedType().Marshal()
+		if err != nil {
+			return err
+		}
+		e.writeUvarint(uint64(len(typeBytes)))
+		if e.err == nil {
+			_, e.err = e.w.Write(typeBytes)
+		}
+		e.writeString(tree.AsStringWithFlags(v, tree.FmtExport))
+	case opt.ColumnID:
+		e.writeUvarint(uint64(privateTagColumnID))
+		e.writeUvarint(uint64(v))
+	case opt.Ordering:
+		e.writeUvarint(uint64(privateTagOrdering))
+		e.writeUvarint(uint64(len(v)))
+		for _, col := range v {
+			e.writeUvarint(uint64(col))
+		}
+	case opt.TableID:
+		e.writeUvarint(uint64(privateTagTableID))
+		e.writeUvarint(uint64(v))
+	default:
+		return fmt.Errorf("memo: export: unsupported private type %T", priv)
+	}
+	return e.err
+}
+
+// readPrivate decodes a single type-tagged private value previously written
+// by writePrivate. evalCtx is required to parse Datum privates back out of
+// their exported string form: tree.ParseDatumStringAs needs it to resolve
+// context-dependent datums such as collated strings, intervals, and
+// timestamps, and errors on every one of those if passed nil.
+func readPrivate(d *importDecoder, evalCtx *tree.EvalContext) (interface{}, error) {
+	tag := privateTag(d.readUvarint())
+	switch tag {
+	case privateTagDatum:
+		n := d.readUvarint()
+		typeBytes := make([]byte, n)
+		if d.err == nil {
+			if _, err := io.ReadFull(d.r, typeBytes); err != nil {
+				d.err = err
+			}
+		}
+		s := d.readString()
+		if d.err != nil {
+			return nil, d.err
+		}
+		var typ types.T
+		if err := typ.Unmarshal(typeBytes); err != nil {
+			return nil, fmt.Errorf("memo: import: decoding datum type: %v", err)
+		}
+		if evalCtx == nil {
+			return nil, fmt.Errorf("memo: import: parsing datum private requires a non-nil EvalContext")
+		}
+		return tree.ParseDatumStringAs(&typ, s, evalCtx)
+	case privateTagColumnID:
+		return opt.ColumnID(d.readUvarint()), d.err
+	case privateTagOrdering:
+		n := d.readUvarint()
+		ordering := make(opt.Ordering, n)
+		for i := range ordering {
+			ordering[i] = opt.OrderingColumn(d.readUvarint())
+		}
+		return ordering, d.err
+	case privateTagTableID:
+		return opt.TableID(d.readUvarint()), d.err
+	default:
+		return nil, fmt.Errorf("memo: import: unknown private tag %d", tag)
+	}
+}